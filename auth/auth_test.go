@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestBearerToken(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Authorization", "Bearer abc.def.ghi")
+
+	if got := BearerToken(req); got != "abc.def.ghi" {
+		t.Fatalf("expected token to be extracted, got %q", got)
+	}
+
+	req.Header.Set("Authorization", "Basic abc")
+	if got := BearerToken(req); got != "" {
+		t.Fatalf("expected non-bearer scheme to be ignored, got %q", got)
+	}
+}
+
+func TestLocalVerifierHS256RoundTrip(t *testing.T) {
+	key := []byte("test-signing-key-------------32b")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   "client-1",
+		"scope": "merlin:write",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign test token: %v", err)
+	}
+
+	verifier, err := NewVerifier(Config{
+		Local: &LocalKeyConfig{Alg: "HS256", Key: base64.StdEncoding.EncodeToString(key)},
+	})
+	if err != nil {
+		t.Fatalf("failed to build verifier: %v", err)
+	}
+
+	claims, err := verifier.Verify(signed)
+	if err != nil {
+		t.Fatalf("expected valid token to verify, got: %v", err)
+	}
+
+	if claims.Subject != "client-1" {
+		t.Fatalf("expected subject 'client-1', got %q", claims.Subject)
+	}
+	if !claims.HasScope("merlin:write") {
+		t.Fatalf("expected claims to carry merlin:write scope, got %v", claims.Scopes)
+	}
+}
+
+// newOIDCTestServer stands in for an issuer's discovery document and JWKS
+// endpoint, serving the public half of key so tests can sign tokens with
+// the private half.
+func newOIDCTestServer(t *testing.T, kid string, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		eBytes := big.NewInt(int64(key.PublicKey.E)).Bytes()
+		body := map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": kid,
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(eBytes),
+			}},
+		}
+		json.NewEncoder(w).Encode(body)
+	})
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"jwks_uri": server.URL + "/.well-known/jwks.json",
+		})
+	})
+
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign test token: %v", err)
+	}
+	return signed
+}
+
+func TestOIDCVerifierAcceptsValidToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+
+	server := newOIDCTestServer(t, "test-key", key)
+	defer server.Close()
+
+	verifier, err := NewVerifier(Config{
+		OIDCIssuers: []string{server.URL},
+		Audience:    "merlin-api",
+	})
+	if err != nil {
+		t.Fatalf("failed to build verifier: %v", err)
+	}
+
+	signed := signRS256(t, key, "test-key", jwt.MapClaims{
+		"iss":   server.URL,
+		"aud":   "merlin-api",
+		"sub":   "client-1",
+		"scope": "merlin:write",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+
+	claims, err := verifier.Verify(signed)
+	if err != nil {
+		t.Fatalf("expected valid token to verify, got: %v", err)
+	}
+	if claims.Subject != "client-1" {
+		t.Fatalf("expected subject 'client-1', got %q", claims.Subject)
+	}
+}
+
+func TestOIDCVerifierRejectsAudienceMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+
+	server := newOIDCTestServer(t, "test-key", key)
+	defer server.Close()
+
+	verifier, err := NewVerifier(Config{
+		OIDCIssuers: []string{server.URL},
+		Audience:    "merlin-api",
+	})
+	if err != nil {
+		t.Fatalf("failed to build verifier: %v", err)
+	}
+
+	signed := signRS256(t, key, "test-key", jwt.MapClaims{
+		"iss": server.URL,
+		"aud": "someone-elses-api",
+		"sub": "client-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	if _, err := verifier.Verify(signed); err == nil {
+		t.Fatal("expected token for the wrong audience to be rejected")
+	}
+}
+
+func TestOIDCVerifierRejectsIssuerMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+
+	server := newOIDCTestServer(t, "test-key", key)
+	defer server.Close()
+
+	verifier, err := NewVerifier(Config{
+		OIDCIssuers: []string{server.URL},
+		Audience:    "merlin-api",
+	})
+	if err != nil {
+		t.Fatalf("failed to build verifier: %v", err)
+	}
+
+	signed := signRS256(t, key, "test-key", jwt.MapClaims{
+		"iss": "https://not-the-configured-issuer.example",
+		"aud": "merlin-api",
+		"sub": "client-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	if _, err := verifier.Verify(signed); err == nil {
+		t.Fatal("expected token from an unexpected issuer to be rejected")
+	}
+}
+
+func TestNewVerifierRequiresAudienceForOIDCIssuers(t *testing.T) {
+	if _, err := NewVerifier(Config{OIDCIssuers: []string{"https://issuer.example"}}); err == nil {
+		t.Fatal("expected NewVerifier to reject an oidc issuer configured with no audience")
+	}
+}
+
+func TestTokenVerifierDelegatesToAuthenticator(t *testing.T) {
+	verifier := NewTokenVerifier(stubAuthenticator{
+		subject: "a-contributor",
+		scopes:  []string{"merlin:write"},
+		ok:      true,
+	})
+
+	claims, err := verifier.Verify("some-opaque-token")
+	if err != nil {
+		t.Fatalf("expected token to verify, got: %v", err)
+	}
+	if claims.Subject != "a-contributor" || !claims.HasScope("merlin:write") {
+		t.Fatalf("expected delegated claims, got %+v", claims)
+	}
+
+	if _, err := NewTokenVerifier(stubAuthenticator{ok: false}).Verify("anything"); err == nil {
+		t.Fatal("expected an unknown token to be rejected")
+	}
+}
+
+type stubAuthenticator struct {
+	subject string
+	scopes  []string
+	ok      bool
+}
+
+func (s stubAuthenticator) Authenticate(tokenString string) (string, []string, bool, error) {
+	if !s.ok {
+		return "", nil, false, nil
+	}
+	return s.subject, s.scopes, true, nil
+}
@@ -3,29 +3,38 @@ package main
 // === DECLS
 
 import (
+	"context"
 	"net/http"
-	"math/rand"
-	"time"
-	"errors"
+	"os"
+	"os/signal"
+	"syscall"
 	"encoding/json"
 	"log"
 	"io/ioutil"
+	"time"
+
+	"github.com/probablytom/projectmerlin/auth"
 )
 
 type (
 
 	Config struct {
-		Secret string `json:"secret"`
-	}
-
-	MemoryMessageStore struct {
-		messages *[]Message
+		Secret               string          `json:"secret"`
+		Store                StoreConfig     `json:"store"`
+		Auth                 auth.Config     `json:"auth"`
+		RateLimit            RateLimitConfig `json:"rate_limit"`
+		CORS                 CORSConfig      `json:"cors"`
+		ShutdownGraceSeconds int             `json:"shutdown_grace_seconds"`
 	}
 
 	Message struct {
 		Contents interface{} `json:"contents"`
 	}
 
+	// AuthMessage is the pre-bearer-token message shape, where the admin
+	// secret travelled in the request body. Deprecated: kept for one
+	// release as a compatibility shim while clients migrate to the
+	// Authorization: Bearer header handled by the auth package.
 	AuthMessage struct {
 		Message  `json:"message"`
 		Secret string `json:"secret"`
@@ -49,81 +58,44 @@ type (
 )
 
 var (
-	authSecret string
-	DefaultAuthStore    = MemoryMessageStore{&[]Message{}}
-	DefaultNonAuthStore = MemoryMessageStore{&[]Message{}}
+	authSecret      string
+	authVerifier    auth.Verifier
+	authRateLimiter *IPRateLimiter
+	tokenStore      TokenStore
+	corsConfig      CORSConfig
+	shutdownGrace   = 10 * time.Second
 )
 
-// === UTILS
-
-func seedRandom() {rand.Seed(time.Now().Unix())}
-
-// === METHODS
-
-func (mms MemoryMessageStore) GetMessage() (Message, error) {
-
-	var messageCount = len(*mms.messages)
-
-	// If nothing's there yet, return nothing.
-	if messageCount == 0 {return Message{}, errors.New("no message available")}
-
-	// Something's there, so return a random element from the slice.
-	return (*mms.messages)[ rand.Intn(messageCount) ], nil
-}
-
-func (mms MemoryMessageStore) StoreMessage(message Message) (error) {
-	*mms.messages = append(*mms.messages, message)
-	return nil
-}
-
-
 // === CORE
 
 // ========  Response writers
 
 func returnEncodingError(resp http.ResponseWriter) () {
-	writeJSONResponse("Bad encoding of input json", false, resp)
+	writeAPIError(resp, APIError{Status: http.StatusBadRequest, Code: "bad_encoding", Message: "Bad encoding of input json"})
 }
 
 func returnBadSecretError(resp http.ResponseWriter) () {
-	writeJSONResponse("Bad secret passed to authorise messages", false, resp)
+	writeAPIError(resp, APIError{Status: http.StatusUnauthorized, Code: "bad_secret", Message: "Bad secret passed to authorise messages"})
+}
+
+func returnInvalidTokenError(resp http.ResponseWriter) () {
+	writeAPIError(resp, APIError{Status: http.StatusUnauthorized, Code: "invalid_token", Message: "Bearer token missing, expired or otherwise invalid"})
 }
 
 func returnStorageError(resp http.ResponseWriter) () {
-	writeJSONResponse("Could not store provided message internally", false, resp)
+	writeAPIError(resp, APIError{Status: http.StatusInternalServerError, Code: "storage_error", Message: "Could not store provided message internally"})
 }
 
 func returnNoAvailableMessageError(resp http.ResponseWriter) () {
-	writeJSONResponse("No message available", false, resp)
+	writeAPIError(resp, APIError{Status: http.StatusNotFound, Code: "no_message", Message: "No message available"})
 }
 
 func returnReceiveSuccess(resp http.ResponseWriter) () {
-	writeJSONResponse("Message successfully stored", true, resp)
+	writeJSON(resp, http.StatusCreated, ReturnStatus{Success: true, Context: "Message successfully stored"})
 }
 
-func writeJSONResponse(context string, success bool, resp http.ResponseWriter) () {
-	encodingErrorMessage := ReturnStatus{Success:success, Context:context}
-	errJson, marshallingErr := json.Marshal(encodingErrorMessage)
-
-	if marshallingErr != nil {
-		//  THIS SHOULD NEVER HAPPEN so I think panicking is appropriate. Maybe handle better in future.
-		panic(marshallingErr)
-	}
-
-	resp.Write(errJson)
-}
-
-
 func writeAsJSON(toWrite interface{}, resp http.ResponseWriter) {
-	messageJSON, err := json.Marshal(toWrite)
-
-	if err != nil {
-		//  THIS SHOULD NEVER HAPPEN so I think panicking is appropriate. Maybe handle better in future.
-		panic(err)
-	}
-
-	resp.Write(messageJSON)
-
+	writeJSON(resp, http.StatusOK, toWrite)
 }
 
 
@@ -149,26 +121,52 @@ func SimpleMessageHandler(recieveStore, sendStore MessageStore, postingAuthRequi
 
 		case "POST":
 			print("Processing 'post'")
-			// Decode the json message from the body
-			newMessage := &AuthMessage{} // Messages *must* be authorised!
-			d := json.NewDecoder(r.Body)
-			encodingError := d.Decode(newMessage)
-			authProvided := newMessage.Secret
-			messageProvided := newMessage.Message
-
-			// If we hit an error, write that error /and then return out of this function early./
-			// We're done if we can't encode the message.
-			if encodingError != nil {
-				returnEncodingError(w)
-				return
-			}
 
-			// Check for authorisation if required
-			if postingAuthRequired {
-				if authProvided != authSecret {
+			var messageProvided Message
+
+			if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+				// Bearer token already verified by auth.Middleware; the body
+				// is just the message now.
+				if postingAuthRequired && !claims.HasScope("merlin:write") {
 					returnBadSecretError(w)
 					return
 				}
+
+				if err := json.NewDecoder(r.Body).Decode(&messageProvided); err != nil {
+					returnEncodingError(w)
+					return
+				}
+
+				log.Printf("storing message authored by %q", claims.Subject)
+
+			} else if auth.BearerToken(r) != "" {
+				// A bearer token was presented but auth.Middleware couldn't
+				// verify it (expired, wrong issuer, unconfigured auth.Config,
+				// clock skew, ...). Don't reinterpret the body as the legacy
+				// shape on the strength of a token that didn't check out.
+				returnInvalidTokenError(w)
+				return
+
+			} else {
+				// Deprecated: shared-secret-in-body shape, kept for one
+				// release while clients migrate to bearer tokens.
+				legacyMessage := &AuthMessage{}
+				if err := json.NewDecoder(r.Body).Decode(legacyMessage); err != nil {
+					returnEncodingError(w)
+					return
+				}
+				messageProvided = legacyMessage.Message
+
+				if postingAuthRequired {
+					if secureCompare(legacyMessage.Secret, authSecret) {
+						log.Print("storing message authored by the master secret")
+					} else if token, ok, err := tokenStore.Authenticate(legacyMessage.Secret); err == nil && ok {
+						log.Printf("storing message authored by token %q", token.ID)
+					} else {
+						returnBadSecretError(w)
+						return
+					}
+				}
 			}
 
 			// Actually store the message, and write a message depending on the success.
@@ -183,8 +181,6 @@ func SimpleMessageHandler(recieveStore, sendStore MessageStore, postingAuthRequi
 				return
 			}
 
-			println("done")
-
 		}
 
 	}
@@ -202,7 +198,18 @@ func NonAuthorisedMessageHandler(recieveStore, sendStore MessageStore) (func(htt
 
 // === Main methods
 
-func ConfigureMerlin()() {
+// ConfigureMerlin reads config.json, sets the admin secret and constructs
+// the pair of message stores (non-authorised, authorised) plus the token
+// store, all described by the "store" section of the config. With no
+// "store" section it falls back to in-memory stores, so existing
+// config.json files keep working unchanged. closeStores releases whatever
+// the store backend is holding open (a no-op for in-memory stores).
+//
+// authVerifier ends up accepting both the JWTs described by conf.Auth and
+// the opaque tokens minted by the admin token API, so a token issued via
+// POST /admin/tokens works as an Authorization: Bearer credential, not just
+// inside the legacy request-body shape.
+func ConfigureMerlin() (nonAuthStore, authStore MessageStore, tokens TokenStore, closeStores func() error) {
 
 	// Get the authorisation secret from config. If it doesn't exist, *abort*.
 	confData, err := ioutil.ReadFile("config.json")
@@ -221,30 +228,89 @@ func ConfigureMerlin()() {
 
 	}
 
+	jwtVerifier, err := auth.NewVerifier(conf.Auth)
+	if err != nil {
+		panic("Bad auth configuration! " + err.Error())
+	}
+
+	rateLimit := conf.RateLimit
+	if rateLimit.Rate == 0 {
+		rateLimit.Rate = 1
+	}
+	if rateLimit.Burst == 0 {
+		rateLimit.Burst = 5
+	}
+	authRateLimiter = NewIPRateLimiter(rateLimit.Rate, rateLimit.Burst)
+
+	corsConfig = conf.CORS
+
+	if conf.ShutdownGraceSeconds > 0 {
+		shutdownGrace = time.Duration(conf.ShutdownGraceSeconds) * time.Second
+	}
+
+	if conf.Store.Driver == "" {
+		tokenStore = NewMemoryTokenStore()
+		nonAuthStore, authStore = MemoryMessageStore{&[]Message{}}, MemoryMessageStore{&[]Message{}}
+		closeStores = func() error { return nil }
+	} else {
+		db, driver, err := openStoreDB(conf.Store)
+		if err != nil {
+			panic("Bad store configuration! Could not open/migrate message store: " + err.Error())
+		}
+		tokenStore = NewSQLTokenStore(db, driver)
+		nonAuthStore, authStore = NewSQLMessageStore(db, driver, "nonauth"), NewSQLMessageStore(db, driver, "auth")
+		closeStores = db.Close
+	}
+	tokens = tokenStore
+
+	authVerifier = auth.Combine(jwtVerifier, auth.NewTokenVerifier(tokenStoreAuthenticator{tokens: tokenStore}))
+
+	return
 }
 
-func Serve(nonAuthStore, authStore MessageStore) {
+// Serve builds the route mux, wraps it in the shared middleware chain and
+// starts listening in the background. The caller is responsible for
+// shutting the returned *http.Server down.
+func Serve(nonAuthStore, authStore MessageStore, tokens TokenStore) *http.Server {
+
+	mux := http.NewServeMux()
 
-	// TODO: re-enable this so `nil` can be passed into Serve in the main function
-	//if nonAuthStore == nil {
-	//
-	//	nonAuthStore = MessageStore(DefaultNonAuthStore)
-	//}
-	//
-	//if authStore == nil {
-	//	authStore = MessageStore(DefaultAuthStore)
-	//}
+	adminTokenRoutes := RateLimitMiddleware(authRateLimiter)(auth.Middleware(authVerifier)(RequireAdminMiddleware(AdminTokenRoutes(tokens))))
 
-	// Spin the http server
+	mux.Handle("/", RateLimitMiddleware(authRateLimiter)(auth.Middleware(authVerifier)(http.HandlerFunc(NonAuthorisedMessageHandler(nonAuthStore, authStore)))))
+	mux.Handle("/admin", RateLimitMiddleware(authRateLimiter)(auth.Middleware(authVerifier)(http.HandlerFunc(AuthorisedMessageHandler(authStore, nonAuthStore))))) // TODO: make "admin" token configurable
+	mux.Handle("/admin/tokens", adminTokenRoutes)
+	mux.Handle("/admin/tokens/", adminTokenRoutes)
 
-	http.HandleFunc("/", NonAuthorisedMessageHandler(nonAuthStore, authStore))
-	http.HandleFunc("/admin", AuthorisedMessageHandler(authStore, nonAuthStore)) // TODO: make "admin" token configurable
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	chain := Chain(RequestIDMiddleware, RequestLoggerMiddleware, CORSMiddleware(corsConfig), RecoverAndLogHandler)
 
+	server := &http.Server{Addr: ":8080", Handler: chain(mux)}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	return server
 }
 
 
 func main() {
-	ConfigureMerlin()
-	Serve(DefaultNonAuthStore, DefaultAuthStore)  // Serve with default message stores, TODO: make this configurable by config file
+	nonAuthStore, authStore, tokens, closeStores := ConfigureMerlin()
+	server := Serve(nonAuthStore, authStore, tokens)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("error during server shutdown: %v", err)
+	}
+	if err := closeStores(); err != nil {
+		log.Printf("error closing message store: %v", err)
+	}
 }
@@ -0,0 +1,117 @@
+package main
+
+// === DECLS
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+)
+
+type (
+
+	CORSConfig struct {
+		AllowedOrigins []string `json:"allowed_origins"`
+	}
+
+	// Middleware wraps a handler with cross-cutting behaviour. Chain
+	// composes several into one, applied outermost-first.
+	Middleware func(http.Handler) http.Handler
+)
+
+// Chain composes middlewares so the first one listed runs outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(final http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			final = middlewares[i](final)
+		}
+		return final
+	}
+}
+
+type requestIDContextKey struct{}
+
+// RequestIDFromContext retrieves the id attached by RequestIDMiddleware.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDContextKey{}).(string)
+	return id, ok
+}
+
+func newRequestID() string {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		// crypto/rand failing is effectively unrecoverable; fall back to a
+		// timestamp-derived id so requests are still distinguishable.
+		return hex.EncodeToString([]byte(time.Now().Format(time.RFC3339Nano)))
+	}
+	return hex.EncodeToString(raw)
+}
+
+// RequestIDMiddleware attaches a short random id to each request's context
+// so downstream logs can correlate a single request's activity.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, newRequestID())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code written,
+// since http.ResponseWriter doesn't expose it once WriteHeader is called.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// RequestLoggerMiddleware logs method, path, status, duration and remote
+// address for every request.
+func RequestLoggerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		requestID, _ := RequestIDFromContext(r.Context())
+		log.Printf("request_id=%s method=%s path=%s status=%d duration=%s remote=%s",
+			requestID, r.Method, r.URL.Path, recorder.status, time.Since(start), r.RemoteAddr)
+	})
+}
+
+// CORSMiddleware sets CORS headers for origins in conf.AllowedOrigins (or
+// any origin if conf lists "*") and short-circuits preflight OPTIONS
+// requests.
+func CORSMiddleware(conf CORSConfig) Middleware {
+	allowed := map[string]bool{}
+	for _, origin := range conf.AllowedOrigins {
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			if origin != "" && (allowed["*"] || allowed[origin]) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Merlin-Secret")
+			}
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
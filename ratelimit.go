@@ -0,0 +1,78 @@
+package main
+
+// === DECLS
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type (
+
+	RateLimitConfig struct {
+		Rate  float64 `json:"rate"`
+		Burst int     `json:"burst"`
+	}
+
+	// IPRateLimiter hands out one token-bucket limiter per remote IP,
+	// created lazily on first use.
+	IPRateLimiter struct {
+		mu       sync.Mutex
+		limiters map[string]*rate.Limiter
+		rate     rate.Limit
+		burst    int
+	}
+)
+
+// failedAuthDelay is added before responding once a caller's rate limit is
+// exhausted, so valid and invalid credentials take indistinguishable time.
+const failedAuthDelay = 250 * time.Millisecond
+
+func NewIPRateLimiter(r float64, burst int) *IPRateLimiter {
+	return &IPRateLimiter{
+		limiters: map[string]*rate.Limiter{},
+		rate:     rate.Limit(r),
+		burst:    burst,
+	}
+}
+
+func (rl *IPRateLimiter) Allow(ip string) bool {
+	rl.mu.Lock()
+	limiter, ok := rl.limiters[ip]
+	if !ok {
+		limiter = rate.NewLimiter(rl.rate, rl.burst)
+		rl.limiters[ip] = limiter
+	}
+	rl.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitMiddleware gates requests by remote IP. Once a caller exhausts
+// its bucket, every further request gets the same fixed delay and generic
+// 401, regardless of whether the credentials it carries would otherwise
+// have been valid, so failure modes stay indistinguishable to a prober.
+func RateLimitMiddleware(limiter *IPRateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !limiter.Allow(clientIP(r)) {
+				time.Sleep(failedAuthDelay)
+				returnBadSecretError(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
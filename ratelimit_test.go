@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestIPRateLimiterAllowsUpToBurstThenBlocks(t *testing.T) {
+	limiter := NewIPRateLimiter(1, 2)
+
+	if !limiter.Allow("1.2.3.4") {
+		t.Fatal("expected first request to be allowed")
+	}
+	if !limiter.Allow("1.2.3.4") {
+		t.Fatal("expected second request (within burst) to be allowed")
+	}
+	if limiter.Allow("1.2.3.4") {
+		t.Fatal("expected third request to exceed burst and be blocked")
+	}
+}
+
+func TestIPRateLimiterTracksIPsIndependently(t *testing.T) {
+	limiter := NewIPRateLimiter(1, 1)
+
+	if !limiter.Allow("1.2.3.4") {
+		t.Fatal("expected first IP's first request to be allowed")
+	}
+	if !limiter.Allow("5.6.7.8") {
+		t.Fatal("expected second IP's bucket to be independent")
+	}
+}
+
+func TestSecureCompare(t *testing.T) {
+	if !secureCompare("same-secret", "same-secret") {
+		t.Fatal("expected equal secrets to compare equal")
+	}
+	if secureCompare("secret-a", "secret-b") {
+		t.Fatal("expected different secrets to compare unequal")
+	}
+	if secureCompare("short", "a-much-longer-secret") {
+		t.Fatal("expected different-length secrets to compare unequal")
+	}
+}
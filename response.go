@@ -0,0 +1,58 @@
+package main
+
+// === DECLS
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type (
+
+	// APIError is the uniform JSON error envelope returned for any failed
+	// request. Status isn't serialised; it's also used as the HTTP status
+	// code written alongside the body.
+	APIError struct {
+		Status  int    `json:"-"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+// === CORE
+
+// writeJSON marshals payload and writes it as the response body with the
+// given status code and an explicit Content-Type. Marshalling failures
+// panic rather than return an error; RecoverAndLogHandler turns that into a
+// logged 500 instead of killing the process.
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		//  THIS SHOULD NEVER HAPPEN so I think panicking is appropriate. Maybe handle better in future.
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeAPIError(w http.ResponseWriter, apiErr APIError) {
+	writeJSON(w, apiErr.Status, apiErr)
+}
+
+// RecoverAndLogHandler converts a panic anywhere downstream (most notably
+// from writeJSON/writeAsJSON on a marshalling failure) into a logged 500
+// response instead of taking down the process.
+func RecoverAndLogHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Printf("recovered from panic handling %s %s: %v", r.Method, r.URL.Path, recovered)
+				writeAPIError(w, APIError{Status: http.StatusInternalServerError, Code: "internal_error", Message: "Internal server error"})
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteAPIErrorSetsStatusAndContentType(t *testing.T) {
+	resp := httptest.NewRecorder()
+
+	returnNoAvailableMessageError(resp)
+
+	if resp.Code != 404 {
+		t.Fatalf("expected 404, got %d", resp.Code)
+	}
+	if got := resp.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("expected application/json, got %q", got)
+	}
+}
+
+func TestReturnReceiveSuccessWrites201(t *testing.T) {
+	resp := httptest.NewRecorder()
+
+	returnReceiveSuccess(resp)
+
+	if resp.Code != 201 {
+		t.Fatalf("expected 201, got %d", resp.Code)
+	}
+}
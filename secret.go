@@ -0,0 +1,16 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// secureCompare reports whether a and b are equal without leaking timing
+// information about where they first differ, or their relative lengths.
+// Hashing both sides first means subtle.ConstantTimeCompare always operates
+// on equal-length slices, even when a and b themselves differ in length.
+func secureCompare(a, b string) bool {
+	ah := sha256.Sum256([]byte(a))
+	bh := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ah[:], bh[:]) == 1
+}
@@ -0,0 +1,292 @@
+package main
+
+// === DECLS
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type (
+
+	MemoryMessageStore struct {
+		messages *[]Message
+	}
+
+	// SQLMessageStore persists messages in a SQL database via database/sql.
+	// A single `messages` table is shared between the authorised and
+	// non-authorised stores, distinguished by the `store` column, so two
+	// SQLMessageStore values can be pointed at the same *sql.DB.
+	SQLMessageStore struct {
+		db     *sql.DB
+		driver string
+		store  string
+	}
+
+	StoreConfig struct {
+		Driver string `json:"driver"`
+		DSN    string `json:"dsn"`
+	}
+
+	// SQLTokenStore persists per-client tokens in the `tokens` table of the
+	// same database a SQLMessageStore is pointed at.
+	SQLTokenStore struct {
+		db     *sql.DB
+		driver string
+	}
+)
+
+// === UTILS
+
+func seedRandom() { rand.Seed(time.Now().Unix()) }
+
+// === METHODS
+
+func (mms MemoryMessageStore) GetMessage() (Message, error) {
+
+	var messageCount = len(*mms.messages)
+
+	// If nothing's there yet, return nothing.
+	if messageCount == 0 {
+		return Message{}, errors.New("no message available")
+	}
+
+	// Something's there, so return a random element from the slice.
+	return (*mms.messages)[rand.Intn(messageCount)], nil
+}
+
+func (mms MemoryMessageStore) StoreMessage(message Message) error {
+	*mms.messages = append(*mms.messages, message)
+	return nil
+}
+
+// NewSQLMessageStore wraps an already-open *sql.DB (see openStoreDB) and
+// returns a store bound to the given `store` partition (e.g. "auth" or "nonauth").
+// The caller is responsible for calling migrateMessageStore once per db.
+func NewSQLMessageStore(db *sql.DB, driver, store string) *SQLMessageStore {
+	return &SQLMessageStore{db: db, driver: driver, store: store}
+}
+
+// openStoreDB opens a *sql.DB for the given StoreConfig, defaulting to
+// sqlite3 when no driver is configured.
+func openStoreDB(conf StoreConfig) (*sql.DB, string, error) {
+	driver := conf.Driver
+	if driver == "" {
+		driver = "sqlite3"
+	}
+
+	db, err := sql.Open(driver, conf.DSN)
+	if err != nil {
+		return nil, driver, err
+	}
+
+	if err := migrateMessageStore(db, driver); err != nil {
+		return nil, driver, err
+	}
+
+	if err := migrateTokenStore(db, driver); err != nil {
+		return nil, driver, err
+	}
+
+	return db, driver, nil
+}
+
+// migrateMessageStore creates the `messages` table if it doesn't already
+// exist. It's safe to call every time Merlin starts.
+func migrateMessageStore(db *sql.DB, driver string) error {
+	switch driver {
+	case "postgres":
+		_, err := db.Exec(`
+			CREATE TABLE IF NOT EXISTS messages (
+				id SERIAL PRIMARY KEY,
+				store TEXT NOT NULL,
+				contents TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT now()
+			)`)
+		return err
+	default: // sqlite3
+		_, err := db.Exec(`
+			CREATE TABLE IF NOT EXISTS messages (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				store TEXT NOT NULL,
+				contents TEXT NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`)
+		return err
+	}
+}
+
+func (sms *SQLMessageStore) GetMessage() (Message, error) {
+
+	var query string
+	if sms.driver == "postgres" {
+		query = `SELECT contents FROM messages WHERE store = $1 ORDER BY RANDOM() LIMIT 1`
+	} else {
+		query = `SELECT contents FROM messages WHERE store = ? ORDER BY RANDOM() LIMIT 1`
+	}
+
+	row := sms.db.QueryRow(query, sms.store)
+
+	var raw string
+	if err := row.Scan(&raw); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Message{}, errors.New("no message available")
+		}
+		return Message{}, err
+	}
+
+	var message Message
+	if err := json.Unmarshal([]byte(raw), &message); err != nil {
+		return Message{}, fmt.Errorf("could not decode stored message: %w", err)
+	}
+
+	return message, nil
+}
+
+func (sms *SQLMessageStore) StoreMessage(message Message) error {
+
+	contents, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	var query string
+	if sms.driver == "postgres" {
+		query = `INSERT INTO messages (store, contents, created_at) VALUES ($1, $2, $3)`
+	} else {
+		query = `INSERT INTO messages (store, contents, created_at) VALUES (?, ?, ?)`
+	}
+
+	_, err = sms.db.Exec(query, sms.store, string(contents), time.Now())
+	return err
+}
+
+// migrateTokenStore creates the `tokens` table if it doesn't already exist.
+func migrateTokenStore(db *sql.DB, driver string) error {
+	switch driver {
+	case "postgres":
+		_, err := db.Exec(`
+			CREATE TABLE IF NOT EXISTS tokens (
+				id TEXT PRIMARY KEY,
+				slug TEXT NOT NULL,
+				hashed_key TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT now(),
+				deleted_at TIMESTAMP
+			)`)
+		return err
+	default: // sqlite3
+		_, err := db.Exec(`
+			CREATE TABLE IF NOT EXISTS tokens (
+				id TEXT PRIMARY KEY,
+				slug TEXT NOT NULL,
+				hashed_key TEXT NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				deleted_at DATETIME
+			)`)
+		return err
+	}
+}
+
+func NewSQLTokenStore(db *sql.DB, driver string) *SQLTokenStore {
+	return &SQLTokenStore{db: db, driver: driver}
+}
+
+func (sts *SQLTokenStore) CreateToken(slug string) (Token, string, error) {
+	id, err := generateTokenSecret()
+	if err != nil {
+		return Token{}, "", err
+	}
+	plaintextKey, err := generateTokenSecret()
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	token := Token{ID: id, Slug: slug, CreatedAt: time.Now()}
+
+	var query string
+	if sts.driver == "postgres" {
+		query = `INSERT INTO tokens (id, slug, hashed_key, created_at) VALUES ($1, $2, $3, $4)`
+	} else {
+		query = `INSERT INTO tokens (id, slug, hashed_key, created_at) VALUES (?, ?, ?, ?)`
+	}
+
+	if _, err := sts.db.Exec(query, token.ID, token.Slug, hashTokenKey(plaintextKey), token.CreatedAt); err != nil {
+		return Token{}, "", err
+	}
+
+	return token, plaintextKey, nil
+}
+
+func (sts *SQLTokenStore) ListTokens() ([]Token, error) {
+	rows, err := sts.db.Query(`SELECT id, slug, created_at, deleted_at FROM tokens WHERE deleted_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var token Token
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&token.ID, &token.Slug, &token.CreatedAt, &deletedAt); err != nil {
+			return nil, err
+		}
+		if deletedAt.Valid {
+			token.DeletedAt = &deletedAt.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (sts *SQLTokenStore) RevokeToken(id string) error {
+	var query string
+	if sts.driver == "postgres" {
+		query = `UPDATE tokens SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`
+	} else {
+		query = `UPDATE tokens SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`
+	}
+
+	result, err := sts.db.Exec(query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("token not found")
+	}
+	return nil
+}
+
+func (sts *SQLTokenStore) Authenticate(plaintextKey string) (Token, bool, error) {
+	hashed := hashTokenKey(plaintextKey)
+
+	var query string
+	if sts.driver == "postgres" {
+		query = `SELECT id, slug, created_at FROM tokens WHERE hashed_key = $1 AND deleted_at IS NULL`
+	} else {
+		query = `SELECT id, slug, created_at FROM tokens WHERE hashed_key = ? AND deleted_at IS NULL`
+	}
+
+	var token Token
+	err := sts.db.QueryRow(query, hashed).Scan(&token.ID, &token.Slug, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Token{}, false, nil
+		}
+		return Token{}, false, err
+	}
+
+	return token, true, nil
+}
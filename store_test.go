@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/probablytom/projectmerlin/auth"
+)
+
+// fakeVerifier is a minimal auth.Verifier used to drive SimpleMessageHandler
+// through auth.Middleware without a real signed token.
+type fakeVerifier struct {
+	claims auth.Claims
+	err    error
+}
+
+func (fv fakeVerifier) Verify(tokenString string) (auth.Claims, error) {
+	return fv.claims, fv.err
+}
+
+// fakeMessageStore is a minimal MessageStore used to exercise code that
+// depends on the interface without touching memory or SQL storage.
+type fakeMessageStore struct {
+	toReturn Message
+	err      error
+	stored   []Message
+}
+
+func (fms *fakeMessageStore) GetMessage() (Message, error) {
+	return fms.toReturn, fms.err
+}
+
+func (fms *fakeMessageStore) StoreMessage(message Message) error {
+	fms.stored = append(fms.stored, message)
+	return fms.err
+}
+
+func TestSimpleMessageHandlerStoresIntoProvidedStore(t *testing.T) {
+	// The handler only drives storage through the MessageStore interface, so
+	// a fake swapped in for MemoryMessageStore or SQLMessageStore behaves
+	// identically from its perspective.
+	recieveStore := &fakeMessageStore{}
+	sendStore := &fakeMessageStore{}
+
+	handler := SimpleMessageHandler(recieveStore, sendStore, false)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"message":{"contents":"hello"},"secret":""}`))
+	resp := httptest.NewRecorder()
+
+	handler(resp, req)
+
+	if len(recieveStore.stored) != 1 {
+		t.Fatalf("expected 1 message stored, got %d", len(recieveStore.stored))
+	}
+	if recieveStore.stored[0].Contents != "hello" {
+		t.Fatalf("expected 'hello', got %v", recieveStore.stored[0].Contents)
+	}
+}
+
+func TestSimpleMessageHandlerStoresPlainBodyWithValidBearerToken(t *testing.T) {
+	recieveStore := &fakeMessageStore{}
+	sendStore := &fakeMessageStore{}
+
+	handler := auth.Middleware(fakeVerifier{claims: auth.Claims{Subject: "client-1"}})(
+		http.HandlerFunc(SimpleMessageHandler(recieveStore, sendStore, false)),
+	)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"contents":"hello"}`))
+	req.Header.Set("Authorization", "Bearer some-valid-token")
+	resp := httptest.NewRecorder()
+
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.Code)
+	}
+	if len(recieveStore.stored) != 1 {
+		t.Fatalf("expected 1 message stored, got %d", len(recieveStore.stored))
+	}
+	if recieveStore.stored[0].Contents != "hello" {
+		t.Fatalf("expected 'hello', got %v", recieveStore.stored[0].Contents)
+	}
+}
+
+func TestSimpleMessageHandlerRejectsInvalidBearerTokenInsteadOfFallingBack(t *testing.T) {
+	recieveStore := &fakeMessageStore{}
+	sendStore := &fakeMessageStore{}
+
+	handler := auth.Middleware(fakeVerifier{err: errors.New("token expired")})(
+		http.HandlerFunc(SimpleMessageHandler(recieveStore, sendStore, false)),
+	)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"contents":"hello"}`))
+	req.Header.Set("Authorization", "Bearer some-expired-token")
+	resp := httptest.NewRecorder()
+
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.Code)
+	}
+	if len(recieveStore.stored) != 0 {
+		t.Fatalf("expected no message stored when the bearer token fails verification, got %d", len(recieveStore.stored))
+	}
+}
+
+func TestSQLMessageStoreRoundTrip(t *testing.T) {
+	db, driver, err := openStoreDB(StoreConfig{Driver: "sqlite3", DSN: ":memory:"})
+	if err != nil {
+		t.Fatalf("failed to open/migrate sqlite3 store: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLMessageStore(db, driver, "nonauth")
+
+	if err := store.StoreMessage(Message{Contents: "hello"}); err != nil {
+		t.Fatalf("unexpected error storing message: %v", err)
+	}
+
+	message, err := store.GetMessage()
+	if err != nil {
+		t.Fatalf("expected a stored message to be retrievable, got: %v", err)
+	}
+	if message.Contents != "hello" {
+		t.Fatalf("expected 'hello', got %v", message.Contents)
+	}
+}
+
+func TestSQLMessageStorePartitionsByStore(t *testing.T) {
+	db, driver, err := openStoreDB(StoreConfig{Driver: "sqlite3", DSN: ":memory:"})
+	if err != nil {
+		t.Fatalf("failed to open/migrate sqlite3 store: %v", err)
+	}
+	defer db.Close()
+
+	nonAuth := NewSQLMessageStore(db, driver, "nonauth")
+	auth := NewSQLMessageStore(db, driver, "auth")
+
+	if err := nonAuth.StoreMessage(Message{Contents: "hello"}); err != nil {
+		t.Fatalf("unexpected error storing message: %v", err)
+	}
+
+	if _, err := auth.GetMessage(); err == nil {
+		t.Fatal("expected the auth partition to stay empty when only nonauth was written to")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+// === DECLS
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/probablytom/projectmerlin/auth"
+)
+
+type (
+
+	// Token is the metadata for an issued per-client token and carries no
+	// key: the plaintext key is returned only by CreateToken, and only its
+	// hash is stored.
+	Token struct {
+		ID        string     `json:"id"`
+		Slug      string     `json:"slug"`
+		CreatedAt time.Time  `json:"created_at"`
+		DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	}
+
+	// TokenStore issues and tracks per-client shared keys, backed by
+	// whichever MessageStore backend is configured.
+	TokenStore interface {
+		CreateToken(slug string) (token Token, plaintextKey string, err error)
+		ListTokens() ([]Token, error)
+		RevokeToken(id string) error
+		// Authenticate looks up plaintextKey against stored hashes, returning
+		// the matching token's metadata if it's live (not revoked).
+		Authenticate(plaintextKey string) (Token, bool, error)
+	}
+
+	createTokenRequest struct {
+		Slug string `json:"slug"`
+	}
+
+	createTokenResponse struct {
+		Token
+		Key string `json:"key"`
+	}
+)
+
+// generateTokenSecret returns a random 16-byte base64.RawURLEncoding
+// string, used for both token ids and plaintext shared keys.
+func generateTokenSecret() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashTokenKey(plaintextKey string) string {
+	sum := sha256.Sum256([]byte(plaintextKey))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// === in-memory TokenStore
+
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*storedMemoryToken
+}
+
+type storedMemoryToken struct {
+	Token
+	hashedKey string
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: map[string]*storedMemoryToken{}}
+}
+
+func (mts *MemoryTokenStore) CreateToken(slug string) (Token, string, error) {
+	id, err := generateTokenSecret()
+	if err != nil {
+		return Token{}, "", err
+	}
+	plaintextKey, err := generateTokenSecret()
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	token := Token{ID: id, Slug: slug, CreatedAt: time.Now()}
+
+	mts.mu.Lock()
+	mts.tokens[id] = &storedMemoryToken{Token: token, hashedKey: hashTokenKey(plaintextKey)}
+	mts.mu.Unlock()
+
+	return token, plaintextKey, nil
+}
+
+func (mts *MemoryTokenStore) ListTokens() ([]Token, error) {
+	mts.mu.Lock()
+	defer mts.mu.Unlock()
+
+	tokens := make([]Token, 0, len(mts.tokens))
+	for _, stored := range mts.tokens {
+		tokens = append(tokens, stored.Token)
+	}
+	return tokens, nil
+}
+
+func (mts *MemoryTokenStore) RevokeToken(id string) error {
+	mts.mu.Lock()
+	defer mts.mu.Unlock()
+
+	stored, ok := mts.tokens[id]
+	if !ok || stored.DeletedAt != nil {
+		return errors.New("token not found")
+	}
+	now := time.Now()
+	stored.DeletedAt = &now
+	return nil
+}
+
+func (mts *MemoryTokenStore) Authenticate(plaintextKey string) (Token, bool, error) {
+	hashed := hashTokenKey(plaintextKey)
+
+	mts.mu.Lock()
+	defer mts.mu.Unlock()
+
+	for _, stored := range mts.tokens {
+		if stored.DeletedAt != nil {
+			continue
+		}
+		if secureCompare(stored.hashedKey, hashed) {
+			return stored.Token, true, nil
+		}
+	}
+	return Token{}, false, nil
+}
+
+// tokenStoreAuthenticator adapts a TokenStore to auth.TokenAuthenticator so
+// admin-minted tokens authenticate over the Authorization: Bearer header
+// like any other credential, not only inside the legacy request body. Every
+// live token grants the same "merlin:write" scope; per-token capability
+// scoping is left for a future request.
+type tokenStoreAuthenticator struct {
+	tokens TokenStore
+}
+
+func (ta tokenStoreAuthenticator) Authenticate(tokenString string) (string, []string, bool, error) {
+	token, ok, err := ta.tokens.Authenticate(tokenString)
+	if err != nil || !ok {
+		return "", nil, ok, err
+	}
+	return token.Slug, []string{"merlin:write"}, true, nil
+}
+
+// === admin routes
+
+// AdminTokenRoutes serves POST/GET /admin/tokens and DELETE
+// /admin/tokens/{id}. Callers are expected to have already gated access
+// with RequireAdminMiddleware.
+func AdminTokenRoutes(tokens TokenStore) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/admin/tokens", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			handleCreateToken(w, r, tokens)
+		case "GET":
+			handleListTokens(w, tokens)
+		default:
+			writeAPIError(w, APIError{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: "method not allowed"})
+		}
+	})
+
+	mux.HandleFunc("/admin/tokens/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			writeAPIError(w, APIError{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: "method not allowed"})
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/admin/tokens/")
+		if id == "" {
+			returnEncodingError(w)
+			return
+		}
+		if err := tokens.RevokeToken(id); err != nil {
+			writeAPIError(w, APIError{Status: http.StatusNotFound, Code: "token_not_found", Message: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ReturnStatus{Success: true, Context: "token revoked"})
+	})
+
+	return mux
+}
+
+func handleCreateToken(w http.ResponseWriter, r *http.Request, tokens TokenStore) {
+	req := &createTokenRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		returnEncodingError(w)
+		return
+	}
+
+	token, plaintextKey, err := tokens.CreateToken(req.Slug)
+	if err != nil {
+		returnStorageError(w)
+		return
+	}
+
+	// The plaintext key is only ever returned here; only its hash is stored.
+	writeJSON(w, http.StatusCreated, createTokenResponse{Token: token, Key: plaintextKey})
+}
+
+func handleListTokens(w http.ResponseWriter, tokens TokenStore) {
+	list, err := tokens.ListTokens()
+	if err != nil {
+		returnStorageError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// RequireAdminMiddleware gates a handler behind the master secret or a
+// bearer claim with slug == "*". The master secret travels in a header
+// (rather than the JSON body, like the legacy /admin auth) since these
+// routes also serve GET/DELETE.
+func RequireAdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+			if slug, _ := claims.Raw["slug"].(string); slug == "*" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if secureCompare(r.Header.Get("X-Merlin-Secret"), authSecret) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		returnBadSecretError(w)
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/probablytom/projectmerlin/auth"
+)
+
+func TestMemoryTokenStoreCreateAuthenticateRevoke(t *testing.T) {
+	store := NewMemoryTokenStore()
+
+	token, plaintextKey, err := store.CreateToken("a-contributor")
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	if token.Slug != "a-contributor" {
+		t.Fatalf("expected slug 'a-contributor', got %q", token.Slug)
+	}
+
+	found, ok, err := store.Authenticate(plaintextKey)
+	if err != nil || !ok {
+		t.Fatalf("expected token to authenticate, got ok=%v err=%v", ok, err)
+	}
+	if found.ID != token.ID {
+		t.Fatalf("expected matching token id %q, got %q", token.ID, found.ID)
+	}
+
+	if err := store.RevokeToken(token.ID); err != nil {
+		t.Fatalf("unexpected error revoking token: %v", err)
+	}
+
+	if _, ok, _ := store.Authenticate(plaintextKey); ok {
+		t.Fatal("expected revoked token to no longer authenticate")
+	}
+}
+
+func TestMemoryTokenStoreListOmitsKey(t *testing.T) {
+	store := NewMemoryTokenStore()
+	_, plaintextKey, _ := store.CreateToken("contributor-1")
+
+	tokens, err := store.ListTokens()
+	if err != nil {
+		t.Fatalf("unexpected error listing tokens: %v", err)
+	}
+	if len(tokens) != 1 {
+		t.Fatalf("expected 1 token, got %d", len(tokens))
+	}
+	listed, _ := json.Marshal(tokens)
+	if bytes.Contains(listed, []byte(plaintextKey)) {
+		t.Fatal("expected listed tokens to omit the plaintext key")
+	}
+}
+
+func TestAdminTokenRoutesCreateListDeleteThenReauthFails(t *testing.T) {
+	tokens := NewMemoryTokenStore()
+	routes := AdminTokenRoutes(tokens)
+
+	createBody, _ := json.Marshal(createTokenRequest{Slug: "a-contributor"})
+	createReq := httptest.NewRequest("POST", "/admin/tokens", bytes.NewReader(createBody))
+	createResp := httptest.NewRecorder()
+	routes.ServeHTTP(createResp, createReq)
+
+	if createResp.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating token, got %d", createResp.Code)
+	}
+	var created createTokenResponse
+	if err := json.Unmarshal(createResp.Body.Bytes(), &created); err != nil {
+		t.Fatalf("could not decode create response: %v", err)
+	}
+	if created.Key == "" {
+		t.Fatal("expected plaintext key in create response")
+	}
+	if _, ok, _ := tokens.Authenticate(created.Key); !ok {
+		t.Fatal("expected newly created token to authenticate")
+	}
+
+	listReq := httptest.NewRequest("GET", "/admin/tokens", nil)
+	listResp := httptest.NewRecorder()
+	routes.ServeHTTP(listResp, listReq)
+
+	if listResp.Code != http.StatusOK {
+		t.Fatalf("expected 200 listing tokens, got %d", listResp.Code)
+	}
+	var listed []Token
+	if err := json.Unmarshal(listResp.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("could not decode list response: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != created.ID {
+		t.Fatalf("expected listed token %q, got %+v", created.ID, listed)
+	}
+	if bytes.Contains(listResp.Body.Bytes(), []byte(created.Key)) {
+		t.Fatal("expected listed tokens to omit the plaintext key")
+	}
+
+	deleteReq := httptest.NewRequest("DELETE", "/admin/tokens/"+created.ID, nil)
+	deleteResp := httptest.NewRecorder()
+	routes.ServeHTTP(deleteResp, deleteReq)
+
+	if deleteResp.Code != http.StatusOK {
+		t.Fatalf("expected 200 revoking token, got %d", deleteResp.Code)
+	}
+	if _, ok, _ := tokens.Authenticate(created.Key); ok {
+		t.Fatal("expected revoked token to no longer authenticate")
+	}
+}
+
+func TestRequireAdminMiddlewareAcceptsMasterSecret(t *testing.T) {
+	authSecret = "the-master-secret"
+	defer func() { authSecret = "" }()
+
+	called := false
+	handler := RequireAdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	req := httptest.NewRequest("GET", "/admin/tokens", nil)
+	req.Header.Set("X-Merlin-Secret", "the-master-secret")
+	resp := httptest.NewRecorder()
+
+	handler.ServeHTTP(resp, req)
+
+	if !called {
+		t.Fatal("expected handler to run when the master secret header is correct")
+	}
+}
+
+func TestRequireAdminMiddlewareAcceptsWildcardSlugClaim(t *testing.T) {
+	authSecret = "the-master-secret"
+	defer func() { authSecret = "" }()
+
+	called := false
+	handler := RequireAdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	claims := auth.Claims{Subject: "a-contributor", Raw: jwt.MapClaims{"slug": "*"}}
+	req := httptest.NewRequest("GET", "/admin/tokens", nil)
+	req = req.WithContext(auth.WithClaims(req.Context(), claims))
+	resp := httptest.NewRecorder()
+
+	handler.ServeHTTP(resp, req)
+
+	if !called {
+		t.Fatal("expected handler to run for a claim with slug == \"*\"")
+	}
+}
+
+func TestRequireAdminMiddlewareRejectsEverythingElse(t *testing.T) {
+	authSecret = "the-master-secret"
+	defer func() { authSecret = "" }()
+
+	cases := []struct {
+		name string
+		req  func() *http.Request
+	}{
+		{
+			name: "wrong secret, no claim",
+			req: func() *http.Request {
+				req := httptest.NewRequest("GET", "/admin/tokens", nil)
+				req.Header.Set("X-Merlin-Secret", "not-the-secret")
+				return req
+			},
+		},
+		{
+			name: "non-wildcard slug claim",
+			req: func() *http.Request {
+				claims := auth.Claims{Subject: "a-contributor", Raw: jwt.MapClaims{"slug": "a-contributor"}}
+				req := httptest.NewRequest("GET", "/admin/tokens", nil)
+				return req.WithContext(auth.WithClaims(req.Context(), claims))
+			},
+		},
+		{
+			name: "no secret, no claim",
+			req:  func() *http.Request { return httptest.NewRequest("GET", "/admin/tokens", nil) },
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			called := false
+			handler := RequireAdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, c.req())
+
+			if called {
+				t.Fatal("expected handler not to run")
+			}
+			if resp.Code != http.StatusUnauthorized {
+				t.Fatalf("expected 401, got %d", resp.Code)
+			}
+		})
+	}
+}